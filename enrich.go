@@ -0,0 +1,81 @@
+package traceroute
+
+import (
+	"fmt"
+	"net"
+)
+
+// Enrichment holds the ASN and geolocation data an Enricher found for a hop address
+type Enrichment struct {
+	ASN     int    // Autonomous System number announcing the address, zero if unknown
+	ASName  string // Name of the autonomous system owning ASN
+	Country string // Country in which the address is located
+	City    string // City in which the address is located
+}
+
+// Enricher looks up ASN and geolocation data for a hop address. See the enrich subpackage for
+// ready-made implementations backed by Team Cymru's DNS whois service and MaxMind GeoLite2.
+type Enricher interface {
+	Enrich(addr string) (Enrichment, error)
+}
+
+// defaultEnrichWorkers bounds concurrent Enricher lookups when EnrichWorkers is unset
+const defaultEnrichWorkers = 8
+
+// initEnrich lazily sets up the bounded worker pool shared by every enrichHop call for this Tracer
+func (t *Tracer) initEnrich() {
+	workers := t.EnrichWorkers
+	if workers < 1 {
+		workers = defaultEnrichWorkers
+	}
+	t.enrichSem = make(chan struct{}, workers)
+}
+
+// enrichHop fills in hop's ASN/geolocation fields via t.Enricher, caching the result per address for
+// the lifetime of the Tracer so a router already looked up is not looked up again. Calls for the same
+// address that are in flight at the same time are not merged: each of them can call the Enricher.
+func (t *Tracer) enrichHop(hop *Hop) {
+	if t.Enricher == nil || hop.Address == "" || isBogon(hop.Address) {
+		return
+	}
+
+	if e, ok := t.enrichCache.Load(hop.Address); ok {
+		applyEnrichment(hop, e.(Enrichment))
+		return
+	}
+
+	t.enrichOnce.Do(t.initEnrich)
+	t.enrichSem <- struct{}{}
+	defer func() { <-t.enrichSem }()
+
+	if e, ok := t.enrichCache.Load(hop.Address); ok { // Another goroutine may have filled the cache while we waited for a slot
+		applyEnrichment(hop, e.(Enrichment))
+		return
+	}
+
+	e, err := t.Enricher.Enrich(hop.Address)
+	if err != nil {
+		fmt.Printf("error enriching hop %s: %v\n", hop.Address, err)
+		return
+	}
+	t.enrichCache.Store(hop.Address, e)
+	applyEnrichment(hop, e)
+}
+
+// applyEnrichment copies e's fields onto hop
+func applyEnrichment(hop *Hop, e Enrichment) {
+	hop.ASN = e.ASN
+	hop.ASName = e.ASName
+	hop.Country = e.Country
+	hop.City = e.City
+}
+
+// isBogon reports whether addr is unroutable on the public internet (RFC1918 and similar ranges),
+// and so not worth sending to an Enricher
+func isBogon(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return true
+	}
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
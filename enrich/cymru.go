@@ -0,0 +1,67 @@
+package enrich
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	traceroute "github.com/kjansson/go-traceroute"
+)
+
+// Cymru resolves ASN ownership and country via Team Cymru's DNS-based whois service
+// (https://team-cymru.com/community-services/ip-asn-mapping/), reversing the hop address into an
+// origin.asn.cymru.com query and parsing the "ASN | Prefix | CC | Registry | Alloc" TXT reply.
+type Cymru struct{}
+
+// NewCymru creates a Team Cymru ASN/country Enricher
+func NewCymru() *Cymru {
+	return &Cymru{}
+}
+
+// Enrich looks up the ASN, AS name and country announcing addr
+func (c *Cymru) Enrich(addr string) (traceroute.Enrichment, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() == nil {
+		return traceroute.Enrichment{}, fmt.Errorf("cymru: %q is not a valid IPv4 address", addr)
+	}
+	octets := strings.Split(ip.To4().String(), ".")
+
+	records, err := net.LookupTXT(fmt.Sprintf("%s.%s.%s.%s.origin.asn.cymru.com", octets[3], octets[2], octets[1], octets[0]))
+	if err != nil {
+		return traceroute.Enrichment{}, fmt.Errorf("cymru: origin TXT lookup: %w", err)
+	}
+	if len(records) == 0 {
+		return traceroute.Enrichment{}, fmt.Errorf("cymru: no origin TXT record for %s", addr)
+	}
+
+	fields := strings.Split(records[0], "|")
+	if len(fields) < 3 {
+		return traceroute.Enrichment{}, fmt.Errorf("cymru: unexpected origin TXT record format: %q", records[0])
+	}
+	asn, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return traceroute.Enrichment{}, fmt.Errorf("cymru: invalid ASN in %q: %w", records[0], err)
+	}
+
+	return traceroute.Enrichment{
+		ASN:     asn,
+		ASName:  asName(asn),
+		Country: strings.TrimSpace(fields[2]),
+	}, nil
+}
+
+// asName looks up the human-readable name of asn via a second Cymru TXT query. It is best-effort:
+// an error or malformed reply just leaves the AS name blank rather than failing the whole Enrich call.
+func asName(asn int) string {
+	records, err := net.LookupTXT(fmt.Sprintf("AS%d.asn.cymru.com", asn))
+	if err != nil || len(records) == 0 {
+		return ""
+	}
+	// "ASN | CC | Registry | Alloc date | AS Name"
+	fields := strings.Split(records[0], "|")
+	if len(fields) < 5 {
+		return ""
+	}
+	return strings.TrimSpace(fields[4])
+}
@@ -0,0 +1,60 @@
+package enrich
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+
+	traceroute "github.com/kjansson/go-traceroute"
+)
+
+// MaxMind resolves ASN and geolocation data from an offline MaxMind GeoLite2 database (.mmdb). A
+// single GeoLite2-City.mmdb only yields Country/City, and a single GeoLite2-ASN.mmdb only yields
+// ASN/ASName; point MaxMind at whichever one is available, or call NewMaxMind twice and compose
+// the results with your own Enricher if both are needed.
+type MaxMind struct {
+	reader *geoip2.Reader
+}
+
+// NewMaxMind opens the GeoLite2 database at path. The caller is responsible for calling Close
+// once the Tracer is done enriching hops.
+func NewMaxMind(path string) (*MaxMind, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("maxmind: opening %s: %w", path, err)
+	}
+	return &MaxMind{reader: reader}, nil
+}
+
+// Close releases the underlying database file
+func (m *MaxMind) Close() error {
+	return m.reader.Close()
+}
+
+// Enrich looks up the ASN and geolocation data for addr, leaving whichever fields the open
+// database doesn't cover blank
+func (m *MaxMind) Enrich(addr string) (traceroute.Enrichment, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return traceroute.Enrichment{}, fmt.Errorf("maxmind: %q is not a valid IP address", addr)
+	}
+
+	var e traceroute.Enrichment
+	asn, asnErr := m.reader.ASN(ip)
+	if asnErr == nil {
+		e.ASN = int(asn.AutonomousSystemNumber)
+		e.ASName = asn.AutonomousSystemOrganization
+	}
+
+	city, cityErr := m.reader.City(ip)
+	if cityErr == nil {
+		e.Country = city.Country.Names["en"]
+		e.City = city.City.Names["en"]
+	}
+
+	if asnErr != nil && cityErr != nil {
+		return traceroute.Enrichment{}, fmt.Errorf("maxmind: no data for %s: %w", addr, cityErr)
+	}
+	return e, nil
+}
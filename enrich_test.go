@@ -0,0 +1,70 @@
+package traceroute
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsBogon(t *testing.T) {
+	cases := map[string]bool{
+		"10.0.0.1":     true,
+		"192.168.1.1":  true,
+		"172.16.5.5":   true,
+		"127.0.0.1":    true,
+		"169.254.1.1":  true,
+		"0.0.0.0":      true,
+		"not-an-ip":    true,
+		"8.8.8.8":      false,
+		"2001:4860::1": false,
+		"fc00::1":      true,
+		"::1":          true,
+	}
+	for addr, want := range cases {
+		if got := isBogon(addr); got != want {
+			t.Errorf("isBogon(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+type countingEnricher struct {
+	calls int32
+}
+
+func (c *countingEnricher) Enrich(addr string) (Enrichment, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return Enrichment{ASN: 64500, ASName: "TEST-AS", Country: "US"}, nil
+}
+
+func TestEnrichHopCachesPerAddress(t *testing.T) {
+	enricher := &countingEnricher{}
+	tracer := New()
+	tracer.Enricher = enricher
+
+	for i := 0; i < 5; i++ {
+		hop := Hop{Address: "8.8.8.8"}
+		tracer.enrichHop(&hop)
+		if hop.ASN != 64500 || hop.ASName != "TEST-AS" || hop.Country != "US" {
+			t.Fatalf("expected enrichment to be applied, got %+v", hop)
+		}
+	}
+
+	if calls := atomic.LoadInt32(&enricher.calls); calls != 1 {
+		t.Errorf("expected exactly one Enrich call for a repeated address, got %d", calls)
+	}
+}
+
+func TestEnrichHopSkipsBogonAddresses(t *testing.T) {
+	enricher := &countingEnricher{}
+	tracer := New()
+	tracer.Enricher = enricher
+
+	hop := Hop{Address: "192.168.1.1"}
+	tracer.enrichHop(&hop)
+
+	if hop.ASN != 0 || hop.ASName != "" {
+		t.Errorf("expected bogon address to be skipped, got %+v", hop)
+	}
+	if calls := atomic.LoadInt32(&enricher.calls); calls != 0 {
+		t.Errorf("expected Enrich not to be called for a bogon address, got %d calls", calls)
+	}
+}
@@ -0,0 +1,9 @@
+package traceroute
+
+// Formatter receives hops and the final trace result as they are produced, so a caller can stream
+// structured output (text, JSON, metrics, ...) without re-implementing Trace's bookkeeping. See the
+// output subpackage for ready-made implementations.
+type Formatter interface {
+	WriteHop(Hop) error
+	WriteResult(TraceResult) error
+}
@@ -0,0 +1,241 @@
+package traceroute
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// PathEvent is implemented by every event Monitor can emit
+type PathEvent interface {
+	pathEvent()
+}
+
+// PathEventHopChanged reports that the router answering at TTL changed from Old to New
+type PathEventHopChanged struct {
+	TTL int
+	Old Hop
+	New Hop
+}
+
+func (PathEventHopChanged) pathEvent() {}
+
+// PathEventHopAdded reports that a TTL which was not part of the previous trace results now is, with
+// Hop. Hop.Address is empty if no probe at that TTL was answered.
+type PathEventHopAdded struct {
+	TTL int
+	Hop Hop
+}
+
+func (PathEventHopAdded) pathEvent() {}
+
+// PathEventHopRemoved reports that TTL is no longer part of the trace results (the destination is now
+// reached at a lower TTL); Hop is the hop last confirmed there. A router that stops answering at a TTL
+// that is still traced is reported as a PathEventHopChanged whose New.Address is empty.
+type PathEventHopRemoved struct {
+	TTL int
+	Hop Hop
+}
+
+func (PathEventHopRemoved) pathEvent() {}
+
+// PathEventLatencySpike reports that a hop's RTT rose well above its rolling baseline
+type PathEventLatencySpike struct {
+	TTL      int
+	Baseline float64 // Rolling mean RTT (ms) observed for this hop before the spike
+	Observed float64 // RTT (ms) observed on the run that triggered the event
+}
+
+func (PathEventLatencySpike) pathEvent() {}
+
+const (
+	defaultChangeConfirmRuns = 2
+	defaultSpikeStdDevs      = 3
+	defaultEWMAAlpha         = 0.3
+
+	// minBaselineStdDev floors the RTT standard deviation used for spike detection, so a hop whose
+	// RTT has so far been perfectly stable (zero observed variance) doesn't flag every bit of jitter.
+	minBaselineStdDev = 0.5
+)
+
+// hopMonitorState tracks, per TTL, the last confirmed hop, a rolling RTT baseline, and any change
+// awaiting confirmation across Monitor runs
+type hopMonitorState struct {
+	confirmed    Hop
+	hasConfirmed bool
+
+	meanRTT float64
+	varRTT  float64
+	haveRTT bool
+
+	pendingKind  string // "", "added", "removed", "changed:<addr>" or "spike"
+	pendingEvent PathEvent
+	pendingRuns  int
+}
+
+// Monitor runs Trace repeatedly, once immediately and then every interval, until ctx is done,
+// reporting path and latency changes on the returned channel. A change is only reported once it
+// has persisted for ChangeConfirmRuns consecutive runs, so a one-off routing blip doesn't fire an
+// event; latency spikes fire when a hop's RTT exceeds its rolling baseline by SpikeStdDevs standard
+// deviations. The channel is closed when ctx is done.
+func (t *Tracer) Monitor(ctx context.Context, interval time.Duration) (<-chan PathEvent, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("value of interval must be positive")
+	}
+
+	events := make(chan PathEvent, 16)
+	go t.monitorLoop(ctx, interval, events)
+	return events, nil
+}
+
+func (t *Tracer) monitorLoop(ctx context.Context, interval time.Duration, events chan<- PathEvent) {
+	defer close(events)
+
+	confirmRuns := t.ChangeConfirmRuns
+	if confirmRuns < 1 {
+		confirmRuns = defaultChangeConfirmRuns
+	}
+	spikeStdDevs := t.SpikeStdDevs
+	if spikeStdDevs <= 0 {
+		spikeStdDevs = defaultSpikeStdDevs
+	}
+	alpha := t.EWMAAlpha
+	if alpha <= 0 {
+		alpha = defaultEWMAAlpha
+	}
+
+	states := make(map[int]*hopMonitorState)
+
+	run := func() bool {
+		result, err := t.Trace()
+		if err != nil {
+			fmt.Printf("error tracing during monitor run: %v\n", err)
+			return true
+		}
+		return t.reportPathChanges(ctx, result, states, confirmRuns, spikeStdDevs, alpha, events)
+	}
+
+	if !run() { // Establish a baseline immediately instead of waiting out the first interval
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !run() {
+				return
+			}
+		}
+	}
+}
+
+// reportPathChanges compares result against the per-TTL state built up by previous runs, updating
+// rolling RTT baselines and emitting any change that has now persisted for confirmRuns consecutive
+// runs. It returns false if ctx was done while trying to send an event.
+func (t *Tracer) reportPathChanges(ctx context.Context, result TraceResult, states map[int]*hopMonitorState, confirmRuns int, spikeStdDevs, alpha float64, events chan<- PathEvent) bool {
+
+	currentByTTL := make(map[int]Hop, len(result.Hops))
+	for _, hop := range result.Hops {
+		currentByTTL[hop.TTL] = hop
+	}
+
+	ttls := make(map[int]struct{}, len(states)+len(currentByTTL))
+	for ttl := range states {
+		ttls[ttl] = struct{}{}
+	}
+	for ttl := range currentByTTL {
+		ttls[ttl] = struct{}{}
+	}
+
+	for ttl := range ttls {
+		state, ok := states[ttl]
+		if !ok {
+			state = &hopMonitorState{}
+			states[ttl] = state
+		}
+
+		current, present := currentByTTL[ttl]
+
+		var kind string
+		var ev PathEvent
+		switch {
+		case !state.hasConfirmed && present:
+			kind = "added"
+			ev = PathEventHopAdded{TTL: ttl, Hop: current}
+		case state.hasConfirmed && !present:
+			kind = "removed"
+			ev = PathEventHopRemoved{TTL: ttl, Hop: state.confirmed}
+		case state.hasConfirmed && present && current.Address != state.confirmed.Address:
+			kind = "changed:" + current.Address
+			ev = PathEventHopChanged{TTL: ttl, Old: state.confirmed, New: current}
+		}
+
+		if kind == "" && present { // Same router as last confirmed: track its RTT baseline
+			if !state.haveRTT {
+				state.meanRTT = current.AvgRTT
+				state.varRTT = 0
+				state.haveRTT = true
+			} else {
+				diff := current.AvgRTT - state.meanRTT
+				stddev := math.Max(math.Sqrt(state.varRTT), minBaselineStdDev)
+				if diff > spikeStdDevs*stddev {
+					// Leave the baseline frozen while a spike is in (or pending) effect, so it
+					// doesn't chase the spike itself and mask the next run's confirmation.
+					kind = "spike"
+					ev = PathEventLatencySpike{TTL: ttl, Baseline: state.meanRTT, Observed: current.AvgRTT}
+				} else {
+					incr := alpha * diff
+					state.meanRTT += incr
+					state.varRTT = (1 - alpha) * (state.varRTT + diff*incr)
+				}
+			}
+		}
+		if !present {
+			state.haveRTT = false // No RTT data to track once the hop stops responding
+		}
+
+		if kind == "" {
+			state.pendingKind = ""
+			state.pendingRuns = 0
+			continue
+		}
+
+		if state.pendingKind == kind {
+			state.pendingRuns++
+		} else {
+			state.pendingKind = kind
+			state.pendingRuns = 1
+		}
+		state.pendingEvent = ev
+
+		if state.pendingRuns < confirmRuns {
+			continue
+		}
+
+		select {
+		case events <- state.pendingEvent:
+		case <-ctx.Done():
+			return false
+		}
+
+		switch {
+		case kind == "added":
+			state.confirmed = current
+			state.hasConfirmed = true
+		case kind == "removed":
+			state.hasConfirmed = false
+		case strings.HasPrefix(kind, "changed:"):
+			state.confirmed = current
+		}
+		state.pendingKind = ""
+		state.pendingRuns = 0
+	}
+
+	return true
+}
@@ -0,0 +1,172 @@
+package traceroute
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMonitorReportsHopAddedOnceConfirmed(t *testing.T) {
+	states := make(map[int]*hopMonitorState)
+	ctx := context.Background()
+	events := make(chan PathEvent, 16)
+
+	first := TraceResult{Hops: []Hop{{TTL: 1, Address: "10.0.0.1", AvgRTT: 5}}}
+	if ok := (&Tracer{}).reportPathChanges(ctx, first, states, 2, 3, 0.3, events); !ok {
+		t.Fatalf("reportPathChanges returned false unexpectedly")
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no event before confirmation, got %+v", ev)
+	default:
+	}
+
+	if ok := (&Tracer{}).reportPathChanges(ctx, first, states, 2, 3, 0.3, events); !ok {
+		t.Fatalf("reportPathChanges returned false unexpectedly")
+	}
+	select {
+	case ev := <-events:
+		added, ok := ev.(PathEventHopAdded)
+		if !ok || added.TTL != 1 || added.Hop.Address != "10.0.0.1" {
+			t.Fatalf("expected PathEventHopAdded for TTL 1, got %+v", ev)
+		}
+	default:
+		t.Fatalf("expected a confirmed PathEventHopAdded event")
+	}
+}
+
+func TestMonitorReportsHopRemovedOnceConfirmed(t *testing.T) {
+	states := make(map[int]*hopMonitorState)
+	ctx := context.Background()
+	events := make(chan PathEvent, 16)
+	tr := &Tracer{}
+
+	present := TraceResult{Hops: []Hop{{TTL: 1, Address: "10.0.0.1", AvgRTT: 5}}}
+	tr.reportPathChanges(ctx, present, states, 2, 3, 0.3, events)
+	tr.reportPathChanges(ctx, present, states, 2, 3, 0.3, events)
+	for len(events) > 0 {
+		<-events // Drain the confirmed "added" event
+	}
+
+	absent := TraceResult{}
+	tr.reportPathChanges(ctx, absent, states, 2, 3, 0.3, events)
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no event before confirmation, got %+v", ev)
+	default:
+	}
+
+	tr.reportPathChanges(ctx, absent, states, 2, 3, 0.3, events)
+	select {
+	case ev := <-events:
+		removed, ok := ev.(PathEventHopRemoved)
+		if !ok || removed.TTL != 1 || removed.Hop.Address != "10.0.0.1" {
+			t.Fatalf("expected PathEventHopRemoved for TTL 1, got %+v", ev)
+		}
+	default:
+		t.Fatalf("expected a confirmed PathEventHopRemoved event")
+	}
+}
+
+func TestMonitorReportsHopChangedOnceConfirmed(t *testing.T) {
+	states := make(map[int]*hopMonitorState)
+	ctx := context.Background()
+	events := make(chan PathEvent, 16)
+	tr := &Tracer{}
+
+	original := TraceResult{Hops: []Hop{{TTL: 1, Address: "10.0.0.1", AvgRTT: 5}}}
+	tr.reportPathChanges(ctx, original, states, 2, 3, 0.3, events)
+	tr.reportPathChanges(ctx, original, states, 2, 3, 0.3, events)
+	for len(events) > 0 {
+		<-events // Drain the confirmed "added" event
+	}
+
+	changed := TraceResult{Hops: []Hop{{TTL: 1, Address: "10.0.0.2", AvgRTT: 5}}}
+	tr.reportPathChanges(ctx, changed, states, 2, 3, 0.3, events)
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no event before confirmation, got %+v", ev)
+	default:
+	}
+
+	tr.reportPathChanges(ctx, changed, states, 2, 3, 0.3, events)
+	select {
+	case ev := <-events:
+		hopChanged, ok := ev.(PathEventHopChanged)
+		if !ok || hopChanged.TTL != 1 || hopChanged.Old.Address != "10.0.0.1" || hopChanged.New.Address != "10.0.0.2" {
+			t.Fatalf("expected PathEventHopChanged from 10.0.0.1 to 10.0.0.2 for TTL 1, got %+v", ev)
+		}
+	default:
+		t.Fatalf("expected a confirmed PathEventHopChanged event")
+	}
+}
+
+func TestMonitorReportsLatencySpikeOnceConfirmed(t *testing.T) {
+	states := make(map[int]*hopMonitorState)
+	ctx := context.Background()
+	events := make(chan PathEvent, 16)
+	tr := &Tracer{}
+
+	// Several stable runs to build up a tight RTT baseline
+	for i := 0; i < 5; i++ {
+		result := TraceResult{Hops: []Hop{{TTL: 1, Address: "10.0.0.1", AvgRTT: 10}}}
+		tr.reportPathChanges(ctx, result, states, 2, 3, 0.3, events)
+	}
+	for len(events) > 0 {
+		<-events // Drain the confirmed "added" event from the first run
+	}
+
+	spike := TraceResult{Hops: []Hop{{TTL: 1, Address: "10.0.0.1", AvgRTT: 500}}}
+	tr.reportPathChanges(ctx, spike, states, 2, 3, 0.3, events)
+	select {
+	case ev := <-events:
+		t.Fatalf("expected no spike event before confirmation, got %+v", ev)
+	default:
+	}
+	tr.reportPathChanges(ctx, spike, states, 2, 3, 0.3, events)
+	select {
+	case ev := <-events:
+		s, ok := ev.(PathEventLatencySpike)
+		if !ok || s.TTL != 1 || s.Observed != 500 {
+			t.Fatalf("expected a confirmed PathEventLatencySpike, got %+v", ev)
+		}
+	default:
+		t.Fatalf("expected a confirmed PathEventLatencySpike event")
+	}
+}
+
+func TestMonitorStopsOnContextCancel(t *testing.T) {
+	tracer := New()
+	tracer.Address = "localhost"
+	tracer.Protocol = ProtoICMP
+	tracer.MaxTTL = 2
+	tracer.Timeout = 500 * time.Millisecond
+	tracer.DNSLookup = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := tracer.Monitor(ctx, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Monitor failed: %v", err)
+	}
+
+	<-events // Wait for the first confirmed event, which needs the immediate run and the one after it
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			// Draining any already-queued events is fine, the channel must eventually close
+			for range events {
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the event channel to close after ctx cancellation")
+	}
+}
+
+func TestMonitorRejectsNonPositiveInterval(t *testing.T) {
+	tracer := New()
+	if _, err := tracer.Monitor(context.Background(), 0); err == nil {
+		t.Fatalf("expected an error for a non-positive interval")
+	}
+}
@@ -0,0 +1,28 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+
+	traceroute "github.com/kjansson/go-traceroute"
+)
+
+// JSON writes each hop, and the final result, as a newline-delimited JSON object
+type JSON struct {
+	encoder *json.Encoder
+}
+
+// NewJSON creates a JSON formatter that writes to w
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{encoder: json.NewEncoder(w)}
+}
+
+// WriteHop encodes a single hop as one JSON line
+func (f *JSON) WriteHop(hop traceroute.Hop) error {
+	return f.encoder.Encode(hop)
+}
+
+// WriteResult encodes the full trace result as one JSON line
+func (f *JSON) WriteResult(result traceroute.TraceResult) error {
+	return f.encoder.Encode(result)
+}
@@ -0,0 +1,41 @@
+package output
+
+import (
+	"fmt"
+	"io"
+
+	traceroute "github.com/kjansson/go-traceroute"
+)
+
+// Metrics writes Prometheus-style gauge lines for each hop: traceroute_hop_rtt_ms and
+// traceroute_hop_loss, labeled by target, ttl and responding address. Point Writer at an
+// http.ResponseWriter behind a scrape endpoint.
+type Metrics struct {
+	Writer io.Writer
+	Target string // Label value identifying the traced destination
+}
+
+// NewMetrics creates a Metrics formatter that writes to w, labeling every series with target
+func NewMetrics(w io.Writer, target string) *Metrics {
+	return &Metrics{Writer: w, Target: target}
+}
+
+// WriteHop writes the RTT and loss gauges for a single hop
+func (f *Metrics) WriteHop(hop traceroute.Hop) error {
+	addr := hop.Address
+	if addr == "" {
+		addr = "*"
+	}
+	if _, err := fmt.Fprintf(f.Writer, "traceroute_hop_rtt_ms{target=%q,ttl=\"%d\",addr=%q} %f\n",
+		f.Target, hop.TTL, addr, hop.AvgRTT); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(f.Writer, "traceroute_hop_loss{target=%q,ttl=\"%d\",addr=%q} %f\n",
+		f.Target, hop.TTL, addr, hop.Loss)
+	return err
+}
+
+// WriteResult is a no-op for Metrics: every hop has already been emitted by WriteHop
+func (f *Metrics) WriteResult(traceroute.TraceResult) error {
+	return nil
+}
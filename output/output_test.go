@@ -0,0 +1,70 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	traceroute "github.com/kjansson/go-traceroute"
+)
+
+func testHop() traceroute.Hop {
+	return traceroute.Hop{
+		TTL:     2,
+		Address: "10.0.0.1",
+		Host:    "gw.example.com",
+		AvgRTT:  12.5,
+		Loss:    0.5,
+		Probes: []traceroute.Probe{
+			{Address: "10.0.0.1", RTT: 12.5},
+			{Lost: true},
+		},
+	}
+}
+
+func TestTextWriteHop(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewText(&buf)
+
+	if err := f.WriteHop(testHop()); err != nil {
+		t.Fatalf("WriteHop failed: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "gw.example.com (10.0.0.1)") {
+		t.Errorf("expected host label in output, got %q", out)
+	}
+	if !strings.Contains(out, "12.500 ms") || !strings.Contains(out, "*") {
+		t.Errorf("expected one RTT and one loss marker, got %q", out)
+	}
+}
+
+func TestJSONWriteHop(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewJSON(&buf)
+
+	if err := f.WriteHop(testHop()); err != nil {
+		t.Fatalf("WriteHop failed: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), `"Address":"10.0.0.1"`) {
+		t.Errorf("expected encoded hop address in output, got %q", buf.String())
+	}
+}
+
+func TestMetricsWriteHop(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewMetrics(&buf, "example.com")
+
+	if err := f.WriteHop(testHop()); err != nil {
+		t.Fatalf("WriteHop failed: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, `traceroute_hop_rtt_ms{target="example.com",ttl="2",addr="10.0.0.1"} 12.500000`) {
+		t.Errorf("expected RTT gauge line, got %q", out)
+	}
+	if !strings.Contains(out, `traceroute_hop_loss{target="example.com",ttl="2",addr="10.0.0.1"} 0.500000`) {
+		t.Errorf("expected loss gauge line, got %q", out)
+	}
+}
@@ -0,0 +1,48 @@
+package output
+
+import (
+	"fmt"
+	"io"
+
+	traceroute "github.com/kjansson/go-traceroute"
+)
+
+// Text writes hops in the classic traceroute(8) style: one line per hop with one RTT column per
+// probe, using "*" for any probe that was lost.
+type Text struct {
+	Writer io.Writer
+}
+
+// NewText creates a Text formatter that writes to w
+func NewText(w io.Writer) *Text {
+	return &Text{Writer: w}
+}
+
+// WriteHop writes a single hop line
+func (f *Text) WriteHop(hop traceroute.Hop) error {
+	line := fmt.Sprintf("%2d  %s", hop.TTL, hopLabel(hop))
+	for _, probe := range hop.Probes {
+		if probe.Lost {
+			line += "  *"
+			continue
+		}
+		line += fmt.Sprintf("  %.3f ms", probe.RTT)
+	}
+	_, err := fmt.Fprintln(f.Writer, line)
+	return err
+}
+
+// WriteResult is a no-op for Text: every hop has already been streamed by WriteHop
+func (f *Text) WriteResult(traceroute.TraceResult) error {
+	return nil
+}
+
+func hopLabel(hop traceroute.Hop) string {
+	if hop.Address == "" {
+		return "*"
+	}
+	if hop.Host != "" {
+		return fmt.Sprintf("%s (%s)", hop.Host, hop.Address)
+	}
+	return hop.Address
+}
@@ -1,8 +1,11 @@
 package traceroute
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"os"
 	"strings"
 	"sync"
 	"syscall"
@@ -10,28 +13,88 @@ import (
 
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
-const UnexpectedICMPType = -1 // Represents an unexpected ICMP type
+// Protocol selects which kind of probe is sent for each hop
+type Protocol int
+
+const (
+	ProtoUDP  Protocol = iota // Send a UDP datagram to a (usually unused) destination port, classic traceroute(8) behavior
+	ProtoICMP                 // Send an ICMP Echo Request and wait for a reply, useful when UDP/TCP is filtered
+	ProtoTCP                  // Attempt a TCP handshake, treating a SYN-ACK or RST from the target as "reached"
+)
+
+// icmpOutcome classifies the ICMP response (or lack thereof) observed for a probe
+type icmpOutcome int
+
+const (
+	icmpOutcomeUnexpected icmpOutcome = iota // No response, or an ICMP message unrelated to our probe
+	icmpOutcomeTransit                       // An intermediate hop answered (TTL/hop limit exceeded)
+	icmpOutcomeTerminal                      // The destination was reached (unreachable, echo reply, or TCP response)
+)
 
 // Tracer struct holds configuration and result channel for asynchronous use
 type Tracer struct {
-	Address    string        // Trace target address
-	Port       int           // Destination port
-	StartTTL   int           // Starting TTL value
-	MaxTTL     int           // Maximum TTL value
-	Timeout    time.Duration // Timeout for each hop
-	DNSLookup  bool          // Enable DNS host lookup for hop addresses
-	ResultChan chan Hop      // Channel to send hop results asynchronously
+	Address       string        // Trace target address
+	Port          int           // Destination port for TCP probes; base from which each UDP probe's destination port and each TCP probe's source port are derived
+	Protocol      Protocol      // Probe type used for each hop, defaults to ProtoUDP
+	StartTTL      int           // Starting TTL value
+	MaxTTL        int           // Maximum TTL value
+	Queries       int           // Number of probes sent per TTL, defaults to 3
+	Timeout       time.Duration // Timeout for each probe
+	DNSLookup     bool          // Enable DNS host lookup for hop addresses
+	ResultChan    chan Hop      // Channel to send hop results asynchronously, in TTL order
+	Formatter     Formatter     // Optional formatter invoked for every hop and the final result
+	Enricher      Enricher      // Optional ASN/geolocation enrichment for hop addresses, skipped for RFC1918/bogon addresses
+	EnrichWorkers int           // Bounded worker pool size for Enricher lookups, defaults to 8
+
+	ChangeConfirmRuns int     // Consecutive Monitor runs a path or latency change must persist before it is reported, defaults to 2
+	SpikeStdDevs      float64 // Standard deviations above a hop's rolling baseline RTT that counts as a latency spike, defaults to 3
+	EWMAAlpha         float64 // Smoothing factor for the rolling per-hop RTT baseline used by Monitor, defaults to 0.3
+
+	enrichOnce  sync.Once
+	enrichSem   chan struct{}
+	enrichCache sync.Map // map[string]Enrichment, populated lazily and kept for the lifetime of the Tracer
 }
 
-// Type hop represents a single hop in a traceroute
+// Probe represents the result of a single probe sent for a given TTL
+type Probe struct {
+	Address    string      // Address that replied, empty if this probe was lost
+	Host       string      // Resolved hostname of Address
+	RTT        float64     // Round-trip time in milliseconds, zero if Lost
+	Lost       bool        // True if no reply was received before the timeout
+	Final      bool        // True if this specific probe's reply came from the destination itself
+	MPLSLabels []MPLSLabel // MPLS label stack carried on the reply as an RFC 4950 ICMP extension, if any
+}
+
+// MPLSLabel is a single entry of an MPLS label stack carried on an ICMP TimeExceeded message as
+// an RFC 4950 extension object
+type MPLSLabel struct {
+	Label uint32 // Label value
+	TC    uint8  // Traffic class, formerly experimental use
+	S     bool   // True if this is the bottom of the label stack
+	TTL   uint8  // Time to live copied from the MPLS header
+}
+
+// Hop represents a single hop in a traceroute, aggregated over all probes sent for its TTL
 type Hop struct {
-	TTL       int     // Time To Live value for this hop
-	Address   string  // IP address of the hop
-	Host      string  // Resolved hostname of the hop
-	Latency   float64 // Latency in milliseconds to reach this hop
-	Reachable bool    // Whether the hop was reachable based on ICMP
+	TTL     int     // Time To Live value for this hop
+	Probes  []Probe // Individual probe results, in query order
+	Address string  // Address of the first probe that replied
+	Host    string  // Resolved hostname of Address
+	MinRTT  float64 // Minimum round-trip time across all replying probes, in milliseconds
+	AvgRTT  float64 // Mean round-trip time across all replying probes, in milliseconds
+	MaxRTT  float64 // Maximum round-trip time across all replying probes, in milliseconds
+	StdDev  float64 // Standard deviation of round-trip times across all replying probes, in milliseconds
+	Loss    float64 // Fraction of probes lost at this TTL, between 0 and 1
+	Final   bool    // True if any probe at this TTL reached the destination
+	ASN     int     // Autonomous System number announcing Address, zero if unknown or not enriched
+	ASName  string  // Name of the autonomous system owning ASN
+	Country string  // Country in which Address is located
+	City    string  // City in which Address is located
+
+	MPLSLabels []MPLSLabel // MPLS label stack carried on the reply that set Address, if any
 }
 
 // TraceResult holds the hops collected during a trace
@@ -44,116 +107,538 @@ func New() *Tracer {
 
 	return &Tracer{
 		Port:       33434,
+		Protocol:   ProtoUDP,
 		StartTTL:   1,
 		MaxTTL:     30,
+		Queries:    3,
 		Timeout:    3 * time.Second,
 		DNSLookup:  true,
 		ResultChan: make(chan Hop, 1024),
 	}
 }
 
-// Trace performs the traceroute operation and returns the collected hops both synchronously and via the ResultChan
+// Trace performs the traceroute operation and returns the collected hops both synchronously and via the ResultChan.
+// All TTLs from StartTTL to MaxTTL are probed concurrently, each with Queries probes; hops are still delivered on
+// ResultChan in TTL order, and trailing hops are dropped once one of them reaches the destination.
 func (t *Tracer) Trace() (TraceResult, error) {
 
 	if t.StartTTL < 1 {
 		return TraceResult{}, fmt.Errorf("value of StartTTL must be at least 1")
 	}
+	if t.StartTTL > t.MaxTTL {
+		return TraceResult{}, fmt.Errorf("value of StartTTL must not be greater than MaxTTL")
+	}
 	if t.Address == "" {
 		return TraceResult{}, fmt.Errorf("value of Address must be specified")
 	}
 	if t.Port < 1 || t.Port > 65535 {
 		return TraceResult{}, fmt.Errorf("value of Port must be between 1 and 65535")
 	}
+	if t.Queries < 1 {
+		return TraceResult{}, fmt.Errorf("value of Queries must be at least 1")
+	}
+
+	targetAddr, err := net.ResolveIPAddr("ip", t.Address) // Resolve once so IPv4 vs IPv6 is decided for the whole trace
+	if err != nil {
+		return TraceResult{}, fmt.Errorf("resolving error: %w", err)
+	}
+	isIPv6 := targetAddr.IP.To4() == nil
+
+	type hopResult struct {
+		ttl int
+		hop Hop
+	}
+
+	hopChan := make(chan hopResult, t.MaxTTL-t.StartTTL+1)
+	var wg sync.WaitGroup
+	for ttl := t.StartTTL; ttl <= t.MaxTTL; ttl++ {
+		wg.Add(1)
+		go func(ttl int) {
+			defer wg.Done()
+			hopChan <- hopResult{ttl: ttl, hop: t.probeHop(targetAddr.IP, isIPv6, ttl)}
+		}(ttl)
+	}
+	go func() {
+		wg.Wait()
+		close(hopChan)
+	}()
 
-	ttl := t.StartTTL
-	wg := sync.WaitGroup{}
-	cancelChan := make(chan bool, 1)
+	pending := make(map[int]Hop)
+	next := t.StartTTL
+	done := false
 	traceResult := TraceResult{}
+	for res := range hopChan {
+		pending[res.ttl] = res.hop
+		for {
+			hop, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if done {
+				continue
+			}
+			traceResult.Hops = append(traceResult.Hops, hop) // Store hop result
+			t.ResultChan <- hop                              // Send hop result to result channel for asynchronous processing
+			if t.Formatter != nil {
+				if err := t.Formatter.WriteHop(hop); err != nil {
+					fmt.Printf("error writing hop to formatter: %v\n", err)
+				}
+			}
+			if hop.Final { // Destination reached, drop any hops past it
+				done = true
+			}
+		}
+	}
 
-	for {
-		resolvedAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", t.Address, t.Port))
-		if err != nil {
-			return traceResult, fmt.Errorf("resolving error: %w", err)
+	if t.Formatter != nil {
+		if err := t.Formatter.WriteResult(traceResult); err != nil {
+			fmt.Printf("error writing result to formatter: %v\n", err)
 		}
+	}
+	return traceResult, nil
+}
 
-		outgoing, err := net.DialUDP("udp", nil, resolvedAddr) // Create UDP connection
-		if err != nil {
-			return traceResult, fmt.Errorf("dial error: %w", err)
+// probeHop sends Queries probes for a single TTL concurrently and aggregates their results into a Hop
+func (t *Tracer) probeHop(targetIP net.IP, isIPv6 bool, ttl int) Hop {
+
+	probes := make([]Probe, t.Queries)
+	var wg sync.WaitGroup
+	for q := 0; q < t.Queries; q++ {
+		wg.Add(1)
+		go func(q int) {
+			defer wg.Done()
+			probes[q] = t.probeOne(targetIP, isIPv6, ttl, q)
+		}(q)
+	}
+	wg.Wait()
+
+	hop := aggregateHop(ttl, probes)
+	t.enrichHop(&hop)
+	return hop
+}
+
+// probeOne dispatches a single probe for the given TTL and query index to the configured Protocol
+func (t *Tracer) probeOne(targetIP net.IP, isIPv6 bool, ttl int, queryIdx int) Probe {
+
+	startTime := time.Now()
+	var probe Probe
+	var err error
+	switch t.Protocol {
+	case ProtoICMP:
+		probe, err = t.probeICMP(targetIP, isIPv6, ttl, queryIdx, startTime)
+	case ProtoTCP:
+		probe, err = t.probeTCP(targetIP, isIPv6, ttl, queryIdx, startTime)
+	default:
+		probe, err = t.probeUDP(targetIP, isIPv6, ttl, queryIdx, startTime)
+	}
+	if err != nil {
+		fmt.Printf("error probing ttl %d: %v\n", ttl, err)
+		return Probe{Lost: true}
+	}
+	return probe
+}
+
+// aggregateHop computes the per-TTL summary statistics (min/avg/max/stddev RTT, loss) from its probes
+func aggregateHop(ttl int, probes []Probe) Hop {
+
+	hop := Hop{TTL: ttl, Probes: probes}
+
+	lost := 0
+	var rtts []float64
+	for _, p := range probes {
+		if p.Lost {
+			lost++
+			continue
 		}
+		rtts = append(rtts, p.RTT)
+		if hop.Address == "" {
+			hop.Address = p.Address
+			hop.Host = p.Host
+			hop.MPLSLabels = p.MPLSLabels
+		}
+		if p.Final {
+			hop.Final = true
+		}
+	}
+	hop.Loss = float64(lost) / float64(len(probes))
+	if len(rtts) == 0 {
+		return hop
+	}
 
-		rawOutgoing, err := outgoing.SyscallConn() // Get raw connection to be able to set TTL
-		if err != nil {
-			return traceResult, fmt.Errorf("syscall connection error: %w", err)
+	min, max, sum := rtts[0], rtts[0], 0.0
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
 		}
-		err = rawOutgoing.Control(func(fd uintptr) {
-			err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl) // Set TTL
-			if err != nil {
-				fmt.Printf("error setting socket option: %v\n", err)
-			}
-		})
+		if rtt > max {
+			max = rtt
+		}
+		sum += rtt
+	}
+	avg := sum / float64(len(rtts))
+
+	var variance float64
+	for _, rtt := range rtts {
+		variance += (rtt - avg) * (rtt - avg)
+	}
+	variance /= float64(len(rtts))
+
+	hop.MinRTT = min
+	hop.AvgRTT = avg
+	hop.MaxRTT = max
+	hop.StdDev = math.Sqrt(variance)
+	return hop
+}
+
+// probePort derives a Paris-traceroute style port for the given TTL and query index, so concurrent
+// probes sharing a socket type can be told apart by the ICMP errors they provoke. It wraps back into
+// the valid port range for pathological Queries/MaxTTL combinations.
+func probePort(base, ttl, queryIdx, queries int) int {
+	port := base + ttl*queries + queryIdx
+	if port > 65535 {
+		port = ((port - 1) % 65535) + 1
+	}
+	return port
+}
+
+// probeUDP sends a single UDP datagram for the given TTL and query index, varying the destination port
+// Paris-traceroute style so the resulting ICMP response can be matched back to this exact probe
+func (t *Tracer) probeUDP(targetIP net.IP, isIPv6 bool, ttl int, queryIdx int, startTime time.Time) (Probe, error) {
+
+	network := "udp4"
+	if isIPv6 {
+		network = "udp6"
+	}
+
+	port := probePort(t.Port, ttl, queryIdx, t.Queries)
+
+	resolvedAddr, err := net.ResolveUDPAddr(network, net.JoinHostPort(targetIP.String(), fmt.Sprintf("%d", port)))
+	if err != nil {
+		return Probe{}, fmt.Errorf("resolving error: %w", err)
+	}
+
+	outgoing, err := net.DialUDP(network, nil, resolvedAddr) // Create UDP connection
+	if err != nil {
+		return Probe{}, fmt.Errorf("dial error: %w", err)
+	}
+	defer func() { // Ensure connection is closed
+		if err := outgoing.Close(); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rawOutgoing, err := outgoing.SyscallConn() // Get raw connection to be able to set TTL
+	if err != nil {
+		return Probe{}, fmt.Errorf("syscall connection error: %w", err)
+	}
+	err = rawOutgoing.Control(func(fd uintptr) {
+		if sockErr := setHopLimit(fd, isIPv6, ttl); sockErr != nil {
+			fmt.Printf("error setting socket option: %v\n", sockErr)
+		}
+	})
+	if err != nil {
+		return Probe{}, fmt.Errorf("syscall connection error: %w", err)
+	}
+
+	done := make(chan struct{})
+	var hopAddr string
+	var outcome icmpOutcome
+	var mpls []MPLSLabel
+	go func() { // Listen asynchronously for ICMP response
+		defer close(done)
+		hopAddr, outcome, mpls, _ = t.receiveICMPForPort(isIPv6, port, false)
+	}()
+
+	_, err = outgoing.Write([]byte{}) // Send empty UDP packet
+	if err != nil {
+		return Probe{}, fmt.Errorf("write error: %w", err)
+	}
+
+	<-done
+	return t.finishProbe(startTime, hopAddr, outcome, mpls), nil
+}
+
+// probeICMP sends an ICMP Echo Request for the given TTL and query index and waits for either an echo reply
+// or a routing error. Unlike probeUDP, the send and receive happen on the same socket: unprivileged ICMP echo
+// sockets are matched by the kernel on their local port, so a reply to our own probe only ever arrives on the
+// socket that sent it.
+func (t *Tracer) probeICMP(targetIP net.IP, isIPv6 bool, ttl int, queryIdx int, startTime time.Time) (Probe, error) {
+
+	network := "udp4"
+	listenAddr := "0.0.0.0"
+	echoType := icmp.Type(ipv4.ICMPTypeEcho)
+	replyType := icmp.Type(ipv4.ICMPTypeEchoReply)
+	proto := 1
+	if isIPv6 {
+		network = "udp6"
+		listenAddr = "::"
+		echoType = icmp.Type(ipv6.ICMPTypeEchoRequest)
+		replyType = icmp.Type(ipv6.ICMPTypeEchoReply)
+		proto = 58
+	}
+
+	c, err := icmp.ListenPacket(network, listenAddr)
+	if err != nil {
+		return Probe{}, fmt.Errorf("listen packet error: %w", err)
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			fmt.Printf("error closing ICMP connection: %v\n", err)
+		}
+	}()
+
+	if isIPv6 {
+		if err := c.IPv6PacketConn().SetHopLimit(ttl); err != nil {
+			return Probe{}, fmt.Errorf("set hop limit error: %w", err)
+		}
+	} else {
+		if err := c.IPv4PacketConn().SetTTL(ttl); err != nil {
+			return Probe{}, fmt.Errorf("set ttl error: %w", err)
+		}
+	}
+
+	// Seq encodes both the TTL and the query index so replies can be matched to the exact probe that caused
+	// them even though several TTLs and queries are in flight at once.
+	echo := &icmp.Echo{
+		ID:   os.Getpid() & 0xffff,
+		Seq:  ttl*t.Queries + queryIdx,
+		Data: []byte("go-traceroute"),
+	}
+	wb, err := (&icmp.Message{Type: echoType, Code: 0, Body: echo}).Marshal(nil)
+	if err != nil {
+		return Probe{}, fmt.Errorf("marshal error: %w", err)
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(t.Timeout)); err != nil {
+		return Probe{}, fmt.Errorf("set read deadline error: %w", err)
+	}
+	if _, err := c.WriteTo(wb, &net.UDPAddr{IP: targetIP}); err != nil {
+		return Probe{}, fmt.Errorf("write error: %w", err)
+	}
+
+	rb := make([]byte, 1024)
+	for {
+		n, peer, err := c.ReadFrom(rb)
 		if err != nil {
-			return traceResult, fmt.Errorf("syscall connection error: %w", err)
+			return Probe{Lost: true}, nil // Timed out, no response for this probe
 		}
 
-		defer func() { // Ensure connection is closed
-			err = outgoing.Close()
-			if err != nil {
-				fmt.Printf("error closing connection: %v\n", err)
-			}
-		}()
+		rawMessage, err := icmp.ParseMessage(proto, rb[:n])
+		if err != nil {
+			continue // Malformed packet, keep waiting for our reply
+		}
 
-		wg.Add(1)
-		startTime := time.Now()
-		go func() { // Listen asynchronously for ICMP response
-			defer wg.Done()
-			var host []string
-			hopAddr, response, err := t.receiveICMP()
-			latency := time.Since(startTime).Seconds() * 1000
-			if t.DNSLookup {
-				host, _ = net.LookupAddr(hopAddr)
+		var outcome icmpOutcome
+		switch rawMessage.Type {
+		case replyType:
+			// The kernel rewrites the Echo ID of unprivileged ping sockets to its own value on send, so only
+			// the Sequence number (which it leaves untouched) can be used to correlate the reply with our probe.
+			reply, ok := rawMessage.Body.(*icmp.Echo)
+			if !ok || reply.Seq != echo.Seq {
+				continue // A reply to a different probe sharing this listening window
 			}
-			if response != UnexpectedICMPType { // Record response even on errors as long as we got a valid ICMP type
-				hop := Hop{
-					TTL:       ttl,
-					Address:   hopAddr,
-					Latency:   latency,
-					Host:      strings.Join(host, ", "),
-					Reachable: response == ipv4.ICMPTypeTimeExceeded,
-				}
-				traceResult.Hops = append(traceResult.Hops, hop) // Store hop result
-				t.ResultChan <- hop                              // Send hop result to result channel for asynchronous processing
+			outcome = icmpOutcomeTerminal
+		case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded, ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+			gotSeq, ok := embeddedEchoSeq(rawMessage.Body, isIPv6)
+			if !ok || gotSeq != echo.Seq {
+				continue // An intermediate hop's reply to someone else's probe
 			}
-			if err != nil || response == ipv4.ICMPTypeDestinationUnreachable { // Stop tracing if we hit an error or an unreachable destination
-				cancelChan <- true
+			if rawMessage.Type == ipv4.ICMPTypeTimeExceeded || rawMessage.Type == ipv6.ICMPTypeTimeExceeded {
+				outcome = icmpOutcomeTransit
+			} else {
+				outcome = icmpOutcomeTerminal
 			}
-		}()
+		default:
+			continue // Unrelated ICMP traffic
+		}
 
-		_, err = outgoing.Write([]byte{}) // Send empty UDP packet
-		if err != nil {
-			return traceResult, fmt.Errorf("write error: %w", err)
+		address := peer.String()
+		if udpAddr, ok := peer.(*net.UDPAddr); ok {
+			address = udpAddr.IP.String()
 		}
+		return t.finishProbe(startTime, address, outcome, mplsLabels(rawMessage.Body)), nil
+	}
+}
 
-		wg.Wait()
-		ttl++
-		if ttl > t.MaxTTL { // Stop if we reached MaxTTL
-			break
+// embeddedEchoSeq extracts the Sequence number of the original ICMP echo carried inside a TimeExceeded or
+// DestinationUnreachable message, so a reply can be matched to the probe that caused it
+func embeddedEchoSeq(body icmp.MessageBody, isIPv6 bool) (seq int, ok bool) {
+
+	data := embeddedDatagram(body)
+	if data == nil {
+		return 0, false
+	}
+
+	ipHeaderLen := 40 // Fixed IPv6 header length
+	if !isIPv6 {
+		if len(data) == 0 {
+			return 0, false
 		}
-		select {
-		case <-cancelChan: // Tracing done or we hit an error
-			return traceResult, nil
-		default:
+		ipHeaderLen = int(data[0]&0x0f) * 4 // IHL field, in 32-bit words
+	}
+
+	if len(data) < ipHeaderLen+8 { // Need the IP header plus the first 8 bytes of the echoed datagram
+		return 0, false
+	}
+	echo := data[ipHeaderLen:]
+	return int(echo[6])<<8 | int(echo[7]), true
+}
+
+// embeddedTransportPorts extracts the source and destination ports of the original UDP or TCP datagram
+// carried inside a TimeExceeded or DestinationUnreachable message; both protocols share the same first
+// 4 bytes (source port, destination port), well within the 8 bytes RFC 792/4443 guarantee are echoed back
+func embeddedTransportPorts(body icmp.MessageBody, isIPv6 bool) (srcPort, dstPort int, ok bool) {
+
+	data := embeddedDatagram(body)
+	if data == nil {
+		return 0, 0, false
+	}
+
+	ipHeaderLen := 40 // Fixed IPv6 header length
+	if !isIPv6 {
+		if len(data) == 0 {
+			return 0, 0, false
 		}
+		ipHeaderLen = int(data[0]&0x0f) * 4 // IHL field, in 32-bit words
 	}
 
-	return traceResult, nil
+	if len(data) < ipHeaderLen+4 {
+		return 0, 0, false
+	}
+	header := data[ipHeaderLen:]
+	srcPort = int(header[0])<<8 | int(header[1])
+	dstPort = int(header[2])<<8 | int(header[3])
+	return srcPort, dstPort, true
+}
+
+// embeddedDatagram returns the raw original-datagram bytes (IP header onward) carried inside an ICMP
+// TimeExceeded or DestinationUnreachable message body
+func embeddedDatagram(body icmp.MessageBody) []byte {
+	switch b := body.(type) {
+	case *icmp.TimeExceeded:
+		return b.Data
+	case *icmp.DstUnreach:
+		return b.Data
+	default:
+		return nil
+	}
 }
 
-// receiveICMP listens for incoming ICMP packets and returns the address, relevant ICMP type, and any error encountered
-func (t *Tracer) receiveICMP() (string, ipv4.ICMPType, error) {
+// probeTCP attempts a TCP handshake with the given TTL, treating a completed connection or an RST as
+// having reached the destination. Intermediate hops are still only visible via the ICMP errors they send.
+//
+// Unlike probeUDP, the destination port can't be varied to disambiguate concurrent probes: it has to
+// stay pinned to the real service port t.Port. Instead this binds each probe to its own Paris-traceroute
+// style local (source) port, and receiveICMPForPort is told to match on the embedded datagram's source
+// port rather than its destination port, which would otherwise be identical (and so useless for
+// disambiguation) across every concurrent TCP probe.
+func (t *Tracer) probeTCP(targetIP net.IP, isIPv6 bool, ttl int, queryIdx int, startTime time.Time) (Probe, error) {
 
-	c, err := icmp.ListenPacket("udp4", "0.0.0.0") // Set up connection for incoming ICMP packets
+	network := "tcp4"
+	if isIPv6 {
+		network = "tcp6"
+	}
+
+	srcPort := probePort(t.Port, ttl, queryIdx, t.Queries)
+
+	type dialResult struct {
+		reached bool
+		err     error
+	}
+	dialDone := make(chan dialResult, 1)
+	go func() {
+		dialer := net.Dialer{
+			Timeout:   t.Timeout,
+			LocalAddr: &net.TCPAddr{Port: srcPort},
+			Control: func(_, _ string, rawConn syscall.RawConn) error {
+				var sockErr error
+				if err := rawConn.Control(func(fd uintptr) {
+					sockErr = setHopLimit(fd, isIPv6, ttl)
+				}); err != nil {
+					return err
+				}
+				return sockErr
+			},
+		}
+		conn, err := dialer.Dial(network, net.JoinHostPort(targetIP.String(), fmt.Sprintf("%d", t.Port)))
+		if conn != nil {
+			conn.Close()
+		}
+		if err == nil {
+			dialDone <- dialResult{reached: true}
+			return
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) { // Target sent an RST: reached, but nothing is listening
+			dialDone <- dialResult{reached: true}
+			return
+		}
+		dialDone <- dialResult{err: err}
+	}()
+
+	icmpDone := make(chan struct{})
+	var icmpAddr string
+	var icmpResult icmpOutcome
+	var icmpMPLS []MPLSLabel
+	go func() {
+		defer close(icmpDone)
+		icmpAddr, icmpResult, icmpMPLS, _ = t.receiveICMPForPort(isIPv6, srcPort, true)
+	}()
+
+	result := <-dialDone
+	<-icmpDone
+
+	if result.reached {
+		return t.finishProbe(startTime, targetIP.String(), icmpOutcomeTerminal, nil), nil
+	}
+	if icmpResult != icmpOutcomeUnexpected { // An intermediate router answered instead
+		return t.finishProbe(startTime, icmpAddr, icmpResult, icmpMPLS), nil
+	}
+	return Probe{Lost: true}, nil // Timed out, no response for this probe
+}
+
+// finishProbe turns a raw ICMP outcome into a Probe
+func (t *Tracer) finishProbe(startTime time.Time, addr string, outcome icmpOutcome, mpls []MPLSLabel) Probe {
+
+	if outcome == icmpOutcomeUnexpected {
+		return Probe{Lost: true}
+	}
+
+	rtt := time.Since(startTime).Seconds() * 1000 // Measured before the DNS lookup so it doesn't count towards the RTT
+	var host []string
+	if t.DNSLookup {
+		host, _ = net.LookupAddr(addr)
+	}
+	return Probe{
+		Address:    addr,
+		Host:       strings.Join(host, ", "),
+		RTT:        rtt,
+		Final:      outcome == icmpOutcomeTerminal,
+		MPLSLabels: mpls,
+	}
+}
+
+// receiveICMPForPort listens for incoming ICMP (or ICMPv6) packets sent in response to a UDP or TCP probe,
+// filtering on the port embedded in the original datagram so concurrent probes for other (ttl, query)
+// pairs sharing this listening window don't get mismatched with this one. matchSrcPort selects which
+// embedded port to compare expectedPort against: false for UDP, whose varying destination port is what
+// disambiguates probes, true for TCP, whose destination port is pinned to the real service port and so
+// must be disambiguated by the (varying) local/source port instead.
+func (t *Tracer) receiveICMPForPort(isIPv6 bool, expectedPort int, matchSrcPort bool) (string, icmpOutcome, []MPLSLabel, error) {
+
+	network := "udp4"
+	listenAddr := "0.0.0.0"
+	proto := 1
+	if isIPv6 {
+		network = "udp6"
+		listenAddr = "::"
+		proto = 58
+	}
+
+	c, err := icmp.ListenPacket(network, listenAddr) // Set up connection for incoming ICMP packets
 	if err != nil {
-		return "*", 0, fmt.Errorf("listen packet error: %w", err)
+		return "*", icmpOutcomeUnexpected, nil, fmt.Errorf("listen packet error: %w", err)
 	}
 	defer func() {
 		err := c.Close()
@@ -164,30 +649,79 @@ func (t *Tracer) receiveICMP() (string, ipv4.ICMPType, error) {
 
 	err = c.SetReadDeadline(time.Now().Add(t.Timeout))
 	if err != nil {
-		return "*", 0, fmt.Errorf("set read deadline error: %w", err)
+		return "*", icmpOutcomeUnexpected, nil, fmt.Errorf("set read deadline error: %w", err)
 	}
 
 	rb := make([]byte, 1024)
-	n, peer, err := c.ReadFrom(rb) // Read packet
-	if err != nil {
-		return "*", 0, fmt.Errorf("read from error: %w", err)
+	for {
+		n, peer, err := c.ReadFrom(rb) // Read packet
+		if err != nil {
+			return "*", icmpOutcomeUnexpected, nil, fmt.Errorf("read from error: %w", err)
+		}
+
+		rawMessage, err := icmp.ParseMessage(proto, rb[:n])
+		if err != nil {
+			continue // Malformed packet, keep waiting for our reply
+		}
+
+		var outcome icmpOutcome
+		switch rawMessage.Type {
+		case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded:
+			outcome = icmpOutcomeTransit // This is the response we want, packet expired along the way
+		case ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+			outcome = icmpOutcomeTerminal // This means we cant trace further
+		default:
+			continue // Not relevant to UDP/TCP probing
+		}
+
+		srcPort, dstPort, ok := embeddedTransportPorts(rawMessage.Body, isIPv6)
+		gotPort := dstPort
+		if matchSrcPort {
+			gotPort = srcPort
+		}
+		if !ok || gotPort != expectedPort {
+			continue // A reply to a different probe sharing this listening window
+		}
+
+		address := peer.String()
+		if udpAddr, ok := peer.(*net.UDPAddr); ok {
+			address = udpAddr.IP.String()
+		}
+		return address, outcome, mplsLabels(rawMessage.Body), nil
 	}
+}
 
-	rawMessage, err := icmp.ParseMessage(1, rb[:n])
-	if err != nil {
-		return "*", 0, fmt.Errorf("parse message error: %w", err)
+// mplsLabels extracts any MPLS label stack carried as an RFC 4950 extension object on an ICMP
+// TimeExceeded message. golang.org/x/net/icmp already parses extension objects out of the message
+// during ParseMessage, so this only has to pick out the MPLS ones and convert them to our own type.
+func mplsLabels(body icmp.MessageBody) []MPLSLabel {
+	te, ok := body.(*icmp.TimeExceeded)
+	if !ok {
+		return nil
 	}
-	p := strings.Split(peer.String(), ":")
-	address := p[0]
 
-	// Inspect ICMP message type, we are only interested in TimeExceeded and DestinationUnreachable
-	switch rawMessage.Type {
-	case ipv4.ICMPTypeTimeExceeded:
-		return address, ipv4.ICMPTypeTimeExceeded, nil // This is the response we want, packet expired along the way
+	var labels []MPLSLabel
+	for _, ext := range te.Extensions {
+		stack, ok := ext.(*icmp.MPLSLabelStack)
+		if !ok {
+			continue
+		}
+		for _, l := range stack.Labels {
+			labels = append(labels, MPLSLabel{
+				Label: uint32(l.Label),
+				TC:    uint8(l.TC),
+				S:     l.S,
+				TTL:   uint8(l.TTL),
+			})
+		}
+	}
+	return labels
+}
 
-	case ipv4.ICMPTypeDestinationUnreachable: // This means we cant trace further
-		return address, ipv4.ICMPTypeDestinationUnreachable, nil
-	default:
-		return "*", UnexpectedICMPType, fmt.Errorf("unexpected ICMP message type received")
+// setHopLimit sets the per-packet TTL (IPv4) or hop limit (IPv6) on the given socket
+func setHopLimit(fd uintptr, isIPv6 bool, ttl int) error {
+	if isIPv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
 	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
 }
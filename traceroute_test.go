@@ -1,13 +1,38 @@
 package traceroute
 
 import (
+	"net"
 	"testing"
 	"time"
+
+	"golang.org/x/net/icmp"
 )
 
 func TestTraceroute(t *testing.T) {
 	tracer := New()
 	tracer.Address = "localhost"
+	tracer.Protocol = ProtoICMP
+	tracer.MaxTTL = 5
+	tracer.Timeout = 1 * time.Second
+	tracer.DNSLookup = false
+
+	result, err := tracer.Trace()
+	if err != nil {
+		t.Fatalf("Trace failed: %v", err)
+	}
+
+	if len(result.Hops) == 0 {
+		t.Fatalf("Expected at least one hop, got zero")
+	}
+	if len(result.Hops[0].Probes) != tracer.Queries {
+		t.Fatalf("Expected %d probes for the first hop, got %d", tracer.Queries, len(result.Hops[0].Probes))
+	}
+}
+
+func TestTracerouteIPv6(t *testing.T) {
+	tracer := New()
+	tracer.Address = "::1"
+	tracer.Protocol = ProtoICMP
 	tracer.MaxTTL = 5
 	tracer.Timeout = 1 * time.Second
 	tracer.DNSLookup = false
@@ -21,3 +46,90 @@ func TestTraceroute(t *testing.T) {
 		t.Fatalf("Expected at least one hop, got zero")
 	}
 }
+
+func TestTraceRejectsStartTTLAfterMaxTTL(t *testing.T) {
+	tracer := New()
+	tracer.Address = "localhost"
+	tracer.StartTTL = 10
+	tracer.MaxTTL = 5
+
+	if _, err := tracer.Trace(); err == nil {
+		t.Fatalf("expected an error when StartTTL exceeds MaxTTL")
+	}
+}
+
+func TestTracerouteTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close() // The port is now closed, so connecting to it yields an immediate RST
+
+	tracer := New()
+	tracer.Address = "127.0.0.1"
+	tracer.Protocol = ProtoTCP
+	tracer.Port = port
+	tracer.MaxTTL = 3
+	tracer.Timeout = 500 * time.Millisecond
+	tracer.DNSLookup = false
+
+	result, err := tracer.Trace()
+	if err != nil {
+		t.Fatalf("Trace failed: %v", err)
+	}
+	if len(result.Hops) == 0 {
+		t.Fatalf("Expected at least one hop, got zero")
+	}
+	if !result.Hops[0].Final || result.Hops[0].Address != "127.0.0.1" {
+		t.Fatalf("expected the first hop to reach the refused port, got %+v", result.Hops[0])
+	}
+}
+
+func TestProbePortVariesPerTTLAndQuery(t *testing.T) {
+	seen := make(map[int]bool)
+	for ttl := 1; ttl <= 5; ttl++ {
+		for q := 0; q < 3; q++ {
+			port := probePort(33434, ttl, q, 3)
+			if seen[port] {
+				t.Fatalf("port %d reused across (ttl, query) pairs", port)
+			}
+			seen[port] = true
+		}
+	}
+}
+
+func TestProbePortWraps(t *testing.T) {
+	if port := probePort(65534, 1, 2, 1); port < 1 || port > 65535 {
+		t.Fatalf("expected a wrapped port within the valid range, got %d", port)
+	}
+}
+
+func TestMPLSLabels(t *testing.T) {
+	te := &icmp.TimeExceeded{
+		Extensions: []icmp.Extension{
+			&icmp.MPLSLabelStack{
+				Labels: []icmp.MPLSLabel{
+					{Label: 1001, TC: 2, S: true, TTL: 1},
+				},
+			},
+		},
+	}
+
+	labels := mplsLabels(te)
+	if len(labels) != 1 {
+		t.Fatalf("expected 1 label, got %d", len(labels))
+	}
+	if want := (MPLSLabel{Label: 1001, TC: 2, S: true, TTL: 1}); labels[0] != want {
+		t.Errorf("got %+v, want %+v", labels[0], want)
+	}
+}
+
+func TestMPLSLabelsNoExtensions(t *testing.T) {
+	if labels := mplsLabels(&icmp.TimeExceeded{}); labels != nil {
+		t.Errorf("expected nil labels, got %+v", labels)
+	}
+	if labels := mplsLabels(&icmp.DstUnreach{}); labels != nil {
+		t.Errorf("expected nil labels for a non-TimeExceeded body, got %+v", labels)
+	}
+}